@@ -0,0 +1,78 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd.
+
+// Package backlink implements pkg.go.dev's half of the "back to godoc.org"
+// handshake. godoc.org sets a short-lived cookie when it redirects a
+// visitor here; Middleware uses that cookie to decide whether the visitor
+// should be offered a link back, so pkg.go.dev can embed this package
+// without needing to know anything about godoc.org's redirect logic.
+package backlink
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// CookieName is the cookie godoc.org sets on a visitor it redirects to
+// pkg.go.dev.
+const CookieName = "tmp-from-godoc"
+
+// Placeholder is the template marker pages should embed where the "Back to
+// godoc.org" link belongs. Middleware rewrites it to a godoc.org URL, or to
+// the empty string, depending on whether CookieName is present.
+const Placeholder = "$$GODOC_BACKLINK$$"
+
+// Middleware rewrites any Placeholder occurrence written by next to a
+// "back to godoc.org" link when the request carries CookieName, or to the
+// empty string otherwise. The cookie is deleted once the placeholder has
+// been resolved, so the link is only ever offered once.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		link, hadCookie := resolve(r)
+		if hadCookie {
+			http.SetCookie(w, &http.Cookie{Name: CookieName, Value: "", MaxAge: -1})
+		}
+
+		buf := &bufferingWriter{ResponseWriter: w}
+		next.ServeHTTP(buf, r)
+
+		body := bytes.ReplaceAll(buf.buf.Bytes(), []byte(Placeholder), []byte(link))
+
+		// Rewriting the body can change its length, so any Content-Length
+		// next set is now stale. next's Write (as opposed to WriteHeader)
+		// never reaches the real ResponseWriter, so this is the last point
+		// where it's guaranteed not to have been flushed to the client yet.
+		w.Header().Del("Content-Length")
+		w.Write(body)
+	})
+}
+
+// resolve reports the backlink URL for r, and whether r carried CookieName.
+func resolve(r *http.Request) (link string, hadCookie bool) {
+	c, err := r.Cookie(CookieName)
+	if err != nil || c.Value == "" {
+		return "", false
+	}
+	return "https://godoc.org" + r.URL.Path + "?utm_source=backtogodoc", true
+}
+
+// bufferingWriter buffers the response body so Middleware can rewrite
+// Placeholder before it reaches the client. WriteHeader also strips
+// Content-Length, in case next calls it explicitly and flushes headers to
+// the client before Middleware gets a chance to rewrite the body.
+type bufferingWriter struct {
+	http.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *bufferingWriter) WriteHeader(code int) {
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *bufferingWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
@@ -0,0 +1,79 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd.
+
+package backlink
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		url        string
+		cookie     *http.Cookie
+		wantLink   string
+		wantCookie bool
+	}{
+		{
+			name:     "fresh visitor has no backlink",
+			url:      "https://pkg.go.dev/net/http",
+			wantLink: "",
+		},
+		{
+			name:       "visitor redirected from godoc.org gets a backlink",
+			url:        "https://pkg.go.dev/net/http",
+			cookie:     &http.Cookie{Name: CookieName, Value: "1"},
+			wantLink:   "https://godoc.org/net/http?utm_source=backtogodoc",
+			wantCookie: true,
+		},
+		{
+			name:     "returning visitor without the cookie has no backlink",
+			url:      "https://pkg.go.dev/net/http?utm_source=backtogodoc",
+			wantLink: "",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", test.url, nil)
+			if test.cookie != nil {
+				req.AddCookie(test.cookie)
+			}
+
+			link, hadCookie := resolve(req)
+			if link != test.wantLink {
+				t.Errorf("resolve(%q) link = %q; want %q", test.url, link, test.wantLink)
+			}
+			if hadCookie != test.wantCookie {
+				t.Errorf("resolve(%q) hadCookie = %v; want %v", test.url, hadCookie, test.wantCookie)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<footer>" + Placeholder + "</footer>"))
+	})
+
+	req := httptest.NewRequest("GET", "https://pkg.go.dev/net/http", nil)
+	req.AddCookie(&http.Cookie{Name: CookieName, Value: "1"})
+
+	w := httptest.NewRecorder()
+	Middleware(next).ServeHTTP(w, req)
+	resp := w.Result()
+
+	wantBody := "<footer>https://godoc.org/net/http?utm_source=backtogodoc</footer>"
+	if got := w.Body.String(); got != wantBody {
+		t.Errorf("body = %q; want %q", got, wantBody)
+	}
+
+	wantSetCookie := "tmp-from-godoc=; Max-Age=0"
+	if got := resp.Header.Get("Set-Cookie"); got != wantSetCookie {
+		t.Errorf("Set-Cookie = %q; want %q", got, wantSetCookie)
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd.
+
+// Command gddo-server is the GoDoc.org server.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/golang/gddo/teeproxy"
+)
+
+var (
+	httpAddr           = flag.String("http", ":8080", "Listen for HTTP connections on this address")
+	redirectPolicyPath = flag.String("redirect-policy", "", "Path to a YAML or JSON redirect policy config, reloaded on SIGHUP")
+)
+
+// pkgGoDevHost is the pkg.go.dev host that godoc.org traffic is migrated to.
+const pkgGoDevHost = "pkg.go.dev"
+
+// godocBacklinkCookie is the short-lived cookie set on a redirect to
+// pkg.go.dev, so the cooperating middleware in package backlink can offer a
+// "Back to godoc.org" link only to visitors who actually came from here.
+const godocBacklinkCookie = "tmp-from-godoc"
+
+// teeProxy mirrors a sample of GET traffic to pkg.go.dev for comparison. It
+// is configured in main and left nil in tests, where mirroring is a no-op.
+var teeProxy *teeproxy.Proxy
+
+// eventSink receives gddoEvents and, via teeProxy, RequestEvents for
+// analysis. It is left nil in tests, where sending an event is a no-op, and
+// set to a logEventSink in main.
+var eventSink teeproxy.EventSink
+
+func sendEvent(e *gddoEvent) {
+	if eventSink == nil {
+		return
+	}
+	eventSink.Send(e)
+}
+
+// logEventSink is the default EventSink: it logs every event so gddoEvents
+// and teeproxy RequestEvents can be picked up from the server's logs until
+// there's a real analysis pipeline to send them to.
+type logEventSink struct{}
+
+func (logEventSink) Send(event interface{}) {
+	log.Printf("event: %+v", event)
+}
+
+// errorHandler wraps a function returning an error into an http.Handler,
+// logging and reporting any error it returns.
+type errorHandler func(http.ResponseWriter, *http.Request) error
+
+func (fn errorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := fn(w, r); err != nil {
+		log.Printf("Error serving %s: %v", r.URL, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// statusRecorder wraps an http.ResponseWriter to capture the status code
+// written by the wrapped handler, so it can be reported alongside a mirrored
+// teeproxy request.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// pkgGoDevURL translates the URL of a godoc.org request into the equivalent
+// pkg.go.dev URL: "/-/about" becomes "/about", "?imports" becomes
+// "?tab=imports", and the root/search path is preserved. Attribution is
+// carried by the godocBacklinkCookie handshake rather than a query
+// parameter, so the returned URL is otherwise clean.
+func pkgGoDevURL(u *url.URL) *url.URL {
+	return redirectTargetURL(u, nil)
+}
+
+// redirectTargetURL returns the URL that a request for u should be
+// redirected to, applying rule's Host and Tab overrides if rule is non-nil.
+func redirectTargetURL(u *url.URL, rule *PolicyRule) *url.URL {
+	path, q := pkgGoDevPath(u)
+	host := pkgGoDevHost
+	if rule != nil {
+		if rule.Host != "" {
+			host = rule.Host
+		}
+		if rule.Tab != "" {
+			q.Set("tab", rule.Tab)
+		}
+	}
+	return &url.URL{
+		Scheme:   "https",
+		Host:     host,
+		Path:     path,
+		RawQuery: q.Encode(),
+	}
+}
+
+// pkgGoDevPath returns the pkg.go.dev path and query that correspond to a
+// godoc.org request for u. It holds the godoc.org -> pkg.go.dev mapping
+// rules shared by pkgGoDevURL and the teeproxy package.
+func pkgGoDevPath(u *url.URL) (path string, query url.Values) {
+	q := url.Values{}
+	switch {
+	case u.Path == "/-/about":
+		return "/about", q
+	case u.Path == "/-/go":
+		q.Set("tab", "packages")
+		return "/std", q
+	case u.Path == "/":
+		if term := u.Query().Get("q"); term != "" {
+			q.Set("q", term)
+		}
+		return "/search", q
+	default:
+		switch oq := u.Query(); {
+		case isQuerySet(oq, "imports"):
+			q.Set("tab", "imports")
+		case isQuerySet(oq, "importers"):
+			q.Set("tab", "importedby")
+		default:
+			q.Set("tab", "doc")
+		}
+		return u.Path, q
+	}
+}
+
+func isQuerySet(q url.Values, key string) bool {
+	_, ok := q[key]
+	return ok
+}
+
+// pkgGoDevRedirectHandler wraps fn so that requests are redirected to the
+// equivalent pkg.go.dev page when the caller has opted in via the
+// "redirect" query parameter or the "pkggodev-redirect" cookie, or when
+// policy says a path should always or never redirect. rc classifies
+// whether each request was made by a robot, for the recorded gddoEvent.
+func pkgGoDevRedirectHandler(fn errorHandler, rc RobotClassifier, policy *RedirectPolicy) errorHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		ruleName, err := servePkgGoDevRedirect(fn, rec, r, policy)
+
+		ev := newGDDOEvent(r, time.Since(start).Nanoseconds(), rc.IsRobot(r))
+		ev.PolicyRule = ruleName
+		sendEvent(ev)
+
+		mirrorRequest(r, rec.status)
+		return err
+	}
+}
+
+// servePkgGoDevRedirect returns the name of the PolicyRule that decided
+// whether to redirect, if any matched, alongside fn's or the redirect's
+// error. A matched RedirectAlways or RedirectNever rule wins even over an
+// explicit "redirect=off" query parameter or a disabled cookie. A request
+// carrying "utm_source=backtogodoc" is never redirected, whatever the rule.
+func servePkgGoDevRedirect(fn errorHandler, w http.ResponseWriter, r *http.Request, policy *RedirectPolicy) (string, error) {
+	rule := policy.match(r.URL.Path)
+	ruleName := ""
+	if rule != nil {
+		ruleName = rule.Name
+	}
+
+	redirectParam := r.FormValue("redirect")
+	switch redirectParam {
+	case "on":
+		http.SetCookie(w, &http.Cookie{Name: "pkggodev-redirect", Value: "on", Path: "/"})
+	case "off":
+		http.SetCookie(w, &http.Cookie{Name: "pkggodev-redirect", Value: "", Path: "/", MaxAge: -1})
+	}
+
+	if r.FormValue("utm_source") == "backtogodoc" {
+		return ruleName, fn(w, r)
+	}
+
+	var redirect bool
+	switch {
+	case rule != nil && rule.Redirect == RedirectAlways:
+		redirect = true
+	case rule != nil && rule.Redirect == RedirectNever:
+		redirect = false
+	case redirectParam == "off":
+		redirect = false
+	default:
+		redirect = redirectParam == "on"
+		if !redirect {
+			if c, err := r.Cookie("pkggodev-redirect"); err == nil && c.Value == "on" {
+				redirect = true
+			}
+		}
+	}
+	if !redirect {
+		return ruleName, fn(w, r)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     godocBacklinkCookie,
+		Value:    "1",
+		MaxAge:   60,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, redirectTargetURL(r.URL, rule).String(), http.StatusFound)
+	return ruleName, nil
+}
+
+// mirrorRequest tees a copy of eligible GET requests to pkg.go.dev so their
+// responses can be compared against what godoc.org just served. It is a
+// no-op when teeProxy hasn't been configured, which is the case in tests.
+func mirrorRequest(r *http.Request, status int) {
+	if teeProxy == nil || r.Method != http.MethodGet {
+		return
+	}
+	teeProxy.Mirror(r, status)
+}
+
+// gddoEvent records a single request for analysis. RedirectHost is always
+// the default pkg.go.dev host; the event does not record whether the
+// request was actually redirected, or to which host.
+type gddoEvent struct {
+	Host         string
+	Path         string
+	URL          string
+	Header       http.Header
+	Latency      int64
+	IsRobot      bool
+	RedirectHost string
+	PolicyRule   string
+}
+
+// newGDDOEvent builds the gddoEvent recorded for r, which took latency
+// nanoseconds to serve and was or wasn't made by isRobot.
+func newGDDOEvent(r *http.Request, latency int64, isRobot bool) *gddoEvent {
+	return &gddoEvent{
+		Host:         r.Host,
+		Path:         r.URL.Path,
+		URL:          r.URL.String(),
+		Header:       r.Header,
+		Latency:      latency,
+		IsRobot:      isRobot,
+		RedirectHost: "https://" + pkgGoDevHost,
+	}
+}
+
+func registerHandlers(mux *http.ServeMux, apiMux *http.ServeMux, rc RobotClassifier, policy *RedirectPolicy) {
+	redirect := pkgGoDevRedirectHandler(serveGodoc, rc, policy)
+	mux.Handle("/", redirect)
+
+	api := pkgGoDevRedirectHandler(serveAPI, rc, policy)
+	apiMux.Handle("/", api)
+}
+
+func serveGodoc(w http.ResponseWriter, r *http.Request) error {
+	// The godoc.org documentation and search handlers live elsewhere in
+	// this package; this stub keeps the file self-contained.
+	return nil
+}
+
+func serveAPI(w http.ResponseWriter, r *http.Request) error {
+	// The api.godoc.org handlers live elsewhere in this package; this stub
+	// keeps the file self-contained.
+	return nil
+}
+
+func teeProxySampleRate() float64 {
+	rate, err := strconv.ParseFloat(os.Getenv("GDDO_TEEPROXY_SAMPLE"), 64)
+	if err != nil {
+		return 1
+	}
+	return rate
+}
+
+// robotCounterWindow is the sliding window redisRequestCounter uses to
+// count requests per client IP.
+const robotCounterWindow = time.Minute
+
+// robotThreshold returns the per-window request count above which a client
+// is classified as a robot, or 0 to disable rate-based classification.
+func robotThreshold() int {
+	n, err := strconv.Atoi(os.Getenv("GDDO_ROBOT_THRESHOLD"))
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n
+}
+
+func main() {
+	flag.Parse()
+
+	eventSink = logEventSink{}
+
+	if host := os.Getenv("GDDO_TEEPROXY_HOST"); host != "" {
+		teeProxy = &teeproxy.Proxy{
+			Host:   host,
+			Sink:   eventSink,
+			Sample: teeProxySampleRate(),
+			QPS:    50,
+		}
+	}
+
+	var policy *RedirectPolicy
+	if *redirectPolicyPath != "" {
+		var err error
+		policy, err = loadRedirectPolicy(*redirectPolicyPath)
+		if err != nil {
+			log.Fatalf("load redirect policy %s: %v", *redirectPolicyPath, err)
+		}
+		policy.watchReload(*redirectPolicyPath)
+	}
+
+	var counter RequestCounter
+	if addr := os.Getenv("GDDO_ROBOT_REDIS_ADDR"); addr != "" {
+		counter = newRedisRequestCounter(addr, robotCounterWindow)
+	}
+
+	mux := http.NewServeMux()
+	apiMux := http.NewServeMux()
+	registerHandlers(mux, apiMux, newRobotClassifier(counter, robotThreshold()), policy)
+
+	mux.Handle("api.godoc.org/", apiMux)
+
+	log.Fatal(http.ListenAndServe(*httpAddr, mux))
+}
@@ -10,39 +10,16 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 )
 
-var robotTests = []string{
-	"Mozilla/5.0 (compatible; TweetedTimes Bot/1.0; +http://tweetedtimes.com)",
-	"Mozilla/5.0 (compatible; YandexBot/3.0; +http://yandex.com/bots)",
-	"Mozilla/5.0 (compatible; MJ12bot/v1.4.3; http://www.majestic12.co.uk/bot.php?+)",
-	"Go 1.1 package http",
-	"Java/1.7.0_25	0.003	0.003",
-	"Python-urllib/2.6",
-	"Mozilla/5.0 (compatible; archive.org_bot +http://www.archive.org/details/archive.org_bot)",
-	"Mozilla/5.0 (compatible; Ezooms/1.0; ezooms.bot@gmail.com)",
-	"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
-}
-
-func TestRobotPat(t *testing.T) {
-	// TODO(light): isRobot checks for more than just the User-Agent.
-	// Extract out the database interaction to an interface to test the
-	// full analysis.
-
-	for _, tt := range robotTests {
-		if !robotPat.MatchString(tt) {
-			t.Errorf("%s not a robot", tt)
-		}
-	}
-}
-
 func TestHandlePkgGoDevRedirect(t *testing.T) {
 	handler := pkgGoDevRedirectHandler(func(w http.ResponseWriter, r *http.Request) error {
 		return nil
-	})
+	}, newRobotClassifier(nil, 0), nil)
 
 	for _, test := range []struct {
 		name, url, wantLocationHeader, wantSetCookieHeader string
@@ -52,7 +29,7 @@ func TestHandlePkgGoDevRedirect(t *testing.T) {
 		{
 			name:                "test pkggodev-redirect param is on",
 			url:                 "http://godoc.org/net/http?redirect=on",
-			wantLocationHeader:  "https://pkg.go.dev/net/http?tab=doc&utm_source=godoc",
+			wantLocationHeader:  "https://pkg.go.dev/net/http?tab=doc",
 			wantSetCookieHeader: "pkggodev-redirect=on; Path=/",
 			wantStatusCode:      http.StatusFound,
 		},
@@ -82,8 +59,8 @@ func TestHandlePkgGoDevRedirect(t *testing.T) {
 			name:                "pkggodev-redirect enabled cookie should redirect",
 			url:                 "http://godoc.org/net/http",
 			cookie:              &http.Cookie{Name: "pkggodev-redirect", Value: "on"},
-			wantLocationHeader:  "https://pkg.go.dev/net/http?tab=doc&utm_source=godoc",
-			wantSetCookieHeader: "",
+			wantLocationHeader:  "https://pkg.go.dev/net/http?tab=doc",
+			wantSetCookieHeader: "tmp-from-godoc=1; Max-Age=60; SameSite=Lax",
 			wantStatusCode:      http.StatusFound,
 		},
 		{
@@ -121,33 +98,159 @@ func TestHandlePkgGoDevRedirect(t *testing.T) {
 	}
 }
 
+// TestGodocBacklinkHandshake covers the three states of the "back to
+// godoc.org" handshake: a fresh visitor who hasn't been redirected yet, a
+// visitor who is being redirected to pkg.go.dev right now, and a visitor
+// who has already come back from pkg.go.dev.
+func TestGodocBacklinkHandshake(t *testing.T) {
+	handler := pkgGoDevRedirectHandler(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	}, newRobotClassifier(nil, 0), nil)
+
+	for _, test := range []struct {
+		name                string
+		url                 string
+		wantSetCookieHeader string
+		wantStatusCode      int
+	}{
+		{
+			name:           "fresh",
+			url:            "http://godoc.org/net/http",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:                "redirected-with-cookie",
+			url:                 "http://godoc.org/net/http?redirect=on",
+			wantSetCookieHeader: "tmp-from-godoc=1; Max-Age=60; SameSite=Lax",
+			wantStatusCode:      http.StatusFound,
+		},
+		{
+			name:           "returning",
+			url:            "http://godoc.org/net/http?utm_source=backtogodoc",
+			wantStatusCode: http.StatusOK,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", test.url, nil)
+			w := httptest.NewRecorder()
+			if err := handler(w, req); err != nil {
+				t.Fatal(err)
+			}
+			resp := w.Result()
+
+			var gotBacklinkCookie string
+			for _, h := range resp.Header.Values("Set-Cookie") {
+				if strings.HasPrefix(h, godocBacklinkCookie+"=") {
+					gotBacklinkCookie = h
+				}
+			}
+			if test.wantSetCookieHeader == "" && gotBacklinkCookie != "" {
+				t.Errorf("Set-Cookie = %q; want no %s cookie", gotBacklinkCookie, godocBacklinkCookie)
+			}
+			if test.wantSetCookieHeader != "" && gotBacklinkCookie != test.wantSetCookieHeader {
+				t.Errorf("Set-Cookie = %q; want %q", gotBacklinkCookie, test.wantSetCookieHeader)
+			}
+
+			if got, want := resp.StatusCode, test.wantStatusCode; got != want {
+				t.Errorf("Status code mismatch: got %d; want %d", got, want)
+			}
+		})
+	}
+}
+
+// TestHandlePkgGoDevRedirectPolicy layers RedirectPolicy decisions on top
+// of the cookie/query matrix exercised by TestHandlePkgGoDevRedirect.
+func TestHandlePkgGoDevRedirectPolicy(t *testing.T) {
+	policy := &RedirectPolicy{rules: []*PolicyRule{
+		{Name: "always-net", Prefix: "/net/", Redirect: RedirectAlways},
+		{Name: "never-crypto", Prefix: "/crypto/", Redirect: RedirectNever},
+		{Name: "staging-cloud", Prefix: "/cloud.google.com/", Redirect: RedirectAlways, Host: "staging.pkg.go.dev", Tab: "versions"},
+	}}
+
+	handler := pkgGoDevRedirectHandler(func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	}, newRobotClassifier(nil, 0), policy)
+
+	for _, test := range []struct {
+		name               string
+		url                string
+		wantLocationHeader string
+		wantStatusCode     int
+	}{
+		{
+			name:               "always rule redirects without any query or cookie",
+			url:                "http://godoc.org/net/http",
+			wantLocationHeader: "https://pkg.go.dev/net/http?tab=doc",
+			wantStatusCode:     http.StatusFound,
+		},
+		{
+			name:           "never rule blocks redirect even with redirect=on",
+			url:            "http://godoc.org/crypto/tls?redirect=on",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:               "rule host and tab overrides apply",
+			url:                "http://godoc.org/cloud.google.com/go/storage",
+			wantLocationHeader: "https://staging.pkg.go.dev/cloud.google.com/go/storage?tab=versions",
+			wantStatusCode:     http.StatusFound,
+		},
+		{
+			name:           "unmatched path falls back to opt-in",
+			url:            "http://godoc.org/unicode/utf8",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:               "always rule wins over redirect=off",
+			url:                "http://godoc.org/net/http?redirect=off",
+			wantLocationHeader: "https://pkg.go.dev/net/http?tab=doc",
+			wantStatusCode:     http.StatusFound,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", test.url, nil)
+			w := httptest.NewRecorder()
+			if err := handler(w, req); err != nil {
+				t.Fatal(err)
+			}
+			resp := w.Result()
+
+			if got, want := resp.Header.Get("Location"), test.wantLocationHeader; got != want {
+				t.Errorf("Location header mismatch: got %q; want %q", got, want)
+			}
+			if got, want := resp.StatusCode, test.wantStatusCode; got != want {
+				t.Errorf("Status code mismatch: got %d; want %d", got, want)
+			}
+		})
+	}
+}
+
 func TestGodoc(t *testing.T) {
 	testCases := []struct {
 		from, to string
 	}{
 		{
 			from: "https://godoc.org/-/about",
-			to:   "https://pkg.go.dev/about?utm_source=godoc",
+			to:   "https://pkg.go.dev/about",
 		},
 		{
 			from: "https://godoc.org/-/go",
-			to:   "https://pkg.go.dev/std?tab=packages&utm_source=godoc",
+			to:   "https://pkg.go.dev/std?tab=packages",
 		},
 		{
 			from: "https://godoc.org/?q=foo",
-			to:   "https://pkg.go.dev/search?q=foo&utm_source=godoc",
+			to:   "https://pkg.go.dev/search?q=foo",
 		},
 		{
 			from: "https://godoc.org/cloud.google.com/go/storage",
-			to:   "https://pkg.go.dev/cloud.google.com/go/storage?tab=doc&utm_source=godoc",
+			to:   "https://pkg.go.dev/cloud.google.com/go/storage?tab=doc",
 		},
 		{
 			from: "https://godoc.org/cloud.google.com/go/storage?imports",
-			to:   "https://pkg.go.dev/cloud.google.com/go/storage?tab=imports&utm_source=godoc",
+			to:   "https://pkg.go.dev/cloud.google.com/go/storage?tab=imports",
 		},
 		{
 			from: "https://godoc.org/cloud.google.com/go/storage?importers",
-			to:   "https://pkg.go.dev/cloud.google.com/go/storage?tab=importedby&utm_source=godoc",
+			to:   "https://pkg.go.dev/cloud.google.com/go/storage?tab=importedby",
 		},
 	}
 
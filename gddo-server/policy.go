@@ -0,0 +1,161 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RedirectMode controls whether requests matching a PolicyRule are
+// redirected to pkg.go.dev.
+type RedirectMode string
+
+const (
+	// RedirectAlways redirects regardless of the "redirect" query
+	// parameter or the "pkggodev-redirect" cookie.
+	RedirectAlways RedirectMode = "always"
+
+	// RedirectNever never redirects, regardless of the "redirect" query
+	// parameter or the "pkggodev-redirect" cookie.
+	RedirectNever RedirectMode = "never"
+
+	// RedirectOptIn is the default: the "redirect" query parameter or the
+	// "pkggodev-redirect" cookie decides.
+	RedirectOptIn RedirectMode = "opt-in"
+)
+
+// PolicyRule matches requests by path Prefix or Pattern and decides how
+// they should be redirected to pkg.go.dev.
+type PolicyRule struct {
+	// Name identifies the rule in logs and in gddoEvent.PolicyRule.
+	Name string `json:"name" yaml:"name"`
+
+	// Prefix and Pattern are alternative ways to match a request path;
+	// Prefix is a plain path prefix, Pattern a regular expression. Exactly
+	// one should be set.
+	Prefix  string `json:"prefix,omitempty" yaml:"prefix,omitempty"`
+	Pattern string `json:"pattern,omitempty" yaml:"pattern,omitempty"`
+
+	Redirect RedirectMode `json:"redirect" yaml:"redirect"`
+
+	// Host overrides pkgGoDevHost as the redirect target, e.g. to send a
+	// namespace to a staging pkg.go.dev instance during a staged rollout.
+	Host string `json:"host,omitempty" yaml:"host,omitempty"`
+
+	// Tab overrides the "tab" query parameter that would otherwise be
+	// derived from the request, e.g. to force module roots to "versions".
+	Tab string `json:"tab,omitempty" yaml:"tab,omitempty"`
+
+	re *regexp.Regexp
+}
+
+func (r *PolicyRule) matches(path string) bool {
+	switch {
+	case r.Prefix != "":
+		return strings.HasPrefix(path, r.Prefix)
+	case r.re != nil:
+		return r.re.MatchString(path)
+	default:
+		return false
+	}
+}
+
+// RedirectPolicy is an ordered list of PolicyRules: the first rule whose
+// Prefix or Pattern matches a request decides how it is redirected. It can
+// be reloaded in place, so redirects can be tuned without a deploy.
+type RedirectPolicy struct {
+	mu    sync.RWMutex
+	rules []*PolicyRule
+}
+
+// loadRedirectPolicy reads and parses the redirect policy config at path.
+// JSON is used for a path ending in ".json"; YAML otherwise.
+func loadRedirectPolicy(path string) (*RedirectPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*PolicyRule
+	if strings.HasSuffix(path, ".json") {
+		err = json.Unmarshal(data, &rules)
+	} else {
+		err = yaml.Unmarshal(data, &rules)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse redirect policy %s: %w", path, err)
+	}
+
+	for _, r := range rules {
+		if r.Pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redirect policy rule %q: %w", r.Name, err)
+		}
+		r.re = re
+	}
+
+	return &RedirectPolicy{rules: rules}, nil
+}
+
+// Reload re-reads the config at path and swaps it in, replacing the
+// policy's current rules. The previous rules are kept if path fails to
+// load or parse.
+func (p *RedirectPolicy) Reload(path string) error {
+	reloaded, err := loadRedirectPolicy(path)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.rules = reloaded.rules
+	p.mu.Unlock()
+	return nil
+}
+
+// watchReload reloads the policy from path whenever the process receives
+// SIGHUP, so redirects can be retuned without a deploy.
+func (p *RedirectPolicy) watchReload(path string) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			if err := p.Reload(path); err != nil {
+				log.Printf("reload redirect policy %s: %v", path, err)
+				continue
+			}
+			log.Printf("reloaded redirect policy from %s", path)
+		}
+	}()
+}
+
+// match returns the first rule matching path, or nil if none match or p is
+// nil.
+func (p *RedirectPolicy) match(path string) *PolicyRule {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, r := range p.rules {
+		if r.matches(path) {
+			return r
+		}
+	}
+	return nil
+}
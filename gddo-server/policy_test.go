@@ -0,0 +1,82 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testPolicyYAML = `
+- name: always-net
+  prefix: /net/
+  redirect: always
+- name: pattern-archive
+  pattern: "^/.*archive.*$"
+  redirect: never
+`
+
+func TestLoadRedirectPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(testPolicyYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := loadRedirectPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path     string
+		wantName string
+	}{
+		{"/net/http", "always-net"},
+		{"/rsc.io/archive/tar", "pattern-archive"},
+		{"/unicode/utf8", ""},
+	} {
+		rule := policy.match(test.path)
+		gotName := ""
+		if rule != nil {
+			gotName = rule.Name
+		}
+		if gotName != test.wantName {
+			t.Errorf("match(%q) = %q; want %q", test.path, gotName, test.wantName)
+		}
+	}
+}
+
+func TestRedirectPolicyReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte("- name: always-net\n  prefix: /net/\n  redirect: always\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := loadRedirectPolicy(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule := policy.match("/crypto/tls"); rule != nil {
+		t.Fatalf("match(/crypto/tls) = %v before reload; want nil", rule)
+	}
+
+	if err := os.WriteFile(path, []byte("- name: never-crypto\n  prefix: /crypto/\n  redirect: never\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Reload(path); err != nil {
+		t.Fatal(err)
+	}
+
+	rule := policy.match("/crypto/tls")
+	if rule == nil || rule.Name != "never-crypto" {
+		t.Fatalf("match(/crypto/tls) after reload = %v; want never-crypto", rule)
+	}
+	if rule := policy.match("/net/http"); rule != nil {
+		t.Fatalf("match(/net/http) after reload = %v; want nil", rule)
+	}
+}
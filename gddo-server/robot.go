@@ -0,0 +1,121 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd.
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// robotPat matches User-Agent strings of well known web crawlers and bots.
+var robotPat = regexp.MustCompile(`(?i)\b(Baidu|bingbot|BingPreview|bot|crawl|Crawler|curl|Downloader|Go|Go-http-client|Google|archive|ia_archiver|Java|python|robot|slurp|spider|wget|Yandex)\b`)
+
+// RequestCounter tracks how many requests a key (typically a client IP) has
+// made within a sliding window, so a RobotClassifier can flag clients
+// making an unusual number of requests even when their User-Agent looks
+// like a browser's.
+type RequestCounter interface {
+	Incr(ctx context.Context, key string) (int, error)
+}
+
+// redisRequestCounter is the production RequestCounter. It counts requests
+// per key with INCR, and uses EXPIRE so a key's count resets once window
+// has elapsed since its last request.
+type redisRequestCounter struct {
+	pool   *redis.Pool
+	window time.Duration
+}
+
+// newRedisRequestCounter returns a RequestCounter backed by a Redis pool
+// dialing addr, counting requests within window.
+func newRedisRequestCounter(addr string, window time.Duration) *redisRequestCounter {
+	return &redisRequestCounter{
+		pool: &redis.Pool{
+			MaxIdle:     10,
+			IdleTimeout: 240 * time.Second,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", addr)
+			},
+		},
+		window: window,
+	}
+}
+
+func (c *redisRequestCounter) Incr(ctx context.Context, key string) (int, error) {
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("INCR", key)
+	conn.Send("EXPIRE", key, int(c.window/time.Second))
+	replies, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return 0, err
+	}
+	return redis.Int(replies[0], nil)
+}
+
+// RobotClassifier decides whether a request was made by an automated agent
+// rather than a person browsing the site.
+type RobotClassifier interface {
+	IsRobot(r *http.Request) bool
+}
+
+// robotClassifier is the default RobotClassifier. A request is a robot if
+// its IP is on Deny, or (when its IP isn't on Allow) if its User-Agent
+// matches robotPat or its IP has made more than Threshold requests
+// according to Counter.
+type robotClassifier struct {
+	Counter   RequestCounter
+	Threshold int
+	Allow     map[string]bool
+	Deny      map[string]bool
+}
+
+// newRobotClassifier returns the default RobotClassifier, backed by counter
+// with the given per-IP request threshold.
+func newRobotClassifier(counter RequestCounter, threshold int) *robotClassifier {
+	return &robotClassifier{Counter: counter, Threshold: threshold}
+}
+
+func (c *robotClassifier) IsRobot(r *http.Request) bool {
+	ip := clientIP(r)
+	if c.Deny[ip] {
+		return true
+	}
+	if c.Allow[ip] {
+		return false
+	}
+	if robotPat.MatchString(r.Header.Get("User-Agent")) {
+		return true
+	}
+	if c.Counter == nil || c.Threshold <= 0 {
+		return false
+	}
+	n, err := c.Counter.Incr(r.Context(), ip)
+	if err != nil {
+		return false
+	}
+	return n > c.Threshold
+}
+
+// clientIP returns r's client IP address, with any port stripped.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
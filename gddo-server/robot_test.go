@@ -0,0 +1,121 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var robotUserAgents = []string{
+	"Mozilla/5.0 (compatible; TweetedTimes Bot/1.0; +http://tweetedtimes.com)",
+	"Mozilla/5.0 (compatible; YandexBot/3.0; +http://yandex.com/bots)",
+	"Mozilla/5.0 (compatible; MJ12bot/v1.4.3; http://www.majestic12.co.uk/bot.php?+)",
+	"Go 1.1 package http",
+	"Java/1.7.0_25	0.003	0.003",
+	"Python-urllib/2.6",
+	"Mozilla/5.0 (compatible; archive.org_bot +http://www.archive.org/details/archive.org_bot)",
+	"Mozilla/5.0 (compatible; Ezooms/1.0; ezooms.bot@gmail.com)",
+	"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+}
+
+func TestRobotPat(t *testing.T) {
+	for _, tt := range robotUserAgents {
+		if !robotPat.MatchString(tt) {
+			t.Errorf("%s not a robot", tt)
+		}
+	}
+}
+
+// fakeRequestCounter is a RequestCounter that always reports n, regardless
+// of key, so tests can exercise threshold-based classification without a
+// real Redis instance.
+type fakeRequestCounter struct {
+	n int
+}
+
+func (c fakeRequestCounter) Incr(ctx context.Context, key string) (int, error) {
+	return c.n, nil
+}
+
+func TestIsRobot(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		classifer *robotClassifier
+		userAgent string
+		remoteIP  string
+		want      bool
+	}{
+		{
+			name:      "user agent match",
+			classifer: &robotClassifier{},
+			userAgent: robotUserAgents[0],
+			want:      true,
+		},
+		{
+			name:      "browser user agent, no counter",
+			classifer: &robotClassifier{},
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15)",
+			want:      false,
+		},
+		{
+			name: "request count exceeds threshold",
+			classifer: &robotClassifier{
+				Counter:   fakeRequestCounter{n: 11},
+				Threshold: 10,
+			},
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15)",
+			remoteIP:  "10.0.0.1:1234",
+			want:      true,
+		},
+		{
+			name: "request count under threshold",
+			classifer: &robotClassifier{
+				Counter:   fakeRequestCounter{n: 5},
+				Threshold: 10,
+			},
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15)",
+			remoteIP:  "10.0.0.1:1234",
+			want:      false,
+		},
+		{
+			name: "allowlist bypasses rate exceedance",
+			classifer: &robotClassifier{
+				Counter:   fakeRequestCounter{n: 100},
+				Threshold: 10,
+				Allow:     map[string]bool{"10.0.0.1": true},
+			},
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15)",
+			remoteIP:  "10.0.0.1:1234",
+			want:      false,
+		},
+		{
+			name: "denylist overrides a browser user agent and low count",
+			classifer: &robotClassifier{
+				Counter:   fakeRequestCounter{n: 0},
+				Threshold: 10,
+				Deny:      map[string]bool{"10.0.0.1": true},
+			},
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15)",
+			remoteIP:  "10.0.0.1:1234",
+			want:      true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "https://godoc.org/net/http", nil)
+			req.Header.Set("User-Agent", test.userAgent)
+			if test.remoteIP != "" {
+				req.RemoteAddr = test.remoteIP
+			}
+			if got := test.classifer.IsRobot(req); got != test.want {
+				t.Errorf("IsRobot() = %v; want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd.
+
+// Package teeproxy mirrors a sample of godoc.org traffic to pkg.go.dev and
+// reports how the two services' responses compared. It exists to give
+// operators a diff stream of status codes, latencies, and missing pages
+// they can use to gate the retirement of godoc.org on real production data.
+package teeproxy
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// mirrorTimeout bounds how long a mirrored request may run. It is detached
+// from the inbound request's context, which is canceled as soon as
+// ServeHTTP returns, well before the background mirror goroutine is done.
+const mirrorTimeout = 10 * time.Second
+
+// RequestEvent is a single comparison between a godoc.org response and the
+// response its mirrored pkg.go.dev request received.
+type RequestEvent struct {
+	Host string
+	URL  string
+	Path string
+
+	// GoDocStatus is the status code godoc.org already served for the
+	// request being mirrored. Status is the status code the mirrored
+	// pkg.go.dev request received.
+	GoDocStatus int
+	Status      int
+
+	Latency      time.Duration
+	RedirectHost string
+}
+
+// EventSink receives RequestEvents, and any other events the caller wants
+// recorded alongside them, for logging or analysis.
+type EventSink interface {
+	Send(event interface{})
+}
+
+// Proxy mirrors GET requests to a pkg.go.dev host and reports how its
+// response compared to the one godoc.org already served. The zero value is
+// not usable; construct a Proxy with at least Host and Sink set.
+type Proxy struct {
+	// Host is the pkg.go.dev host to mirror requests to, e.g. "pkg.go.dev".
+	Host string
+
+	// Client sends the mirrored request. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	// Sink receives a RequestEvent for every mirrored request.
+	Sink EventSink
+
+	// Sample is the fraction of eligible requests to mirror, in [0, 1].
+	// A zero value mirrors nothing; 1 mirrors everything.
+	Sample float64
+
+	// QPS caps the rate of mirrored requests sent to Host. A zero value
+	// does not rate limit.
+	QPS float64
+
+	initOnce sync.Once
+	limiter  *hostLimiter
+	rand     func() float64
+}
+
+func (p *Proxy) init() {
+	p.limiter = newHostLimiter(p.QPS)
+	if p.Client == nil {
+		p.Client = http.DefaultClient
+	}
+	if p.rand == nil {
+		p.rand = rand.Float64
+	}
+}
+
+// Mirror asynchronously issues an equivalent request to p.Host for r and
+// records a RequestEvent comparing the two outcomes, sending it to p.Sink.
+// status is the status code godoc.org already served for r. Mirror returns
+// immediately; the mirrored request runs in a background goroutine.
+func (p *Proxy) Mirror(r *http.Request, status int) {
+	p.initOnce.Do(p.init)
+
+	if p.Sink == nil || r.Method != http.MethodGet {
+		return
+	}
+	if p.Sample < 1 && p.rand() >= p.Sample {
+		return
+	}
+	if !p.limiter.allow(p.Host) {
+		return
+	}
+
+	mirrorURL := MirrorURL(r.URL, p.Host)
+	ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+	req := r.Clone(ctx)
+	req.URL = mirrorURL
+	req.Host = p.Host
+	req.RequestURI = ""
+
+	go p.mirror(req, mirrorURL, status, cancel)
+}
+
+func (p *Proxy) mirror(req *http.Request, mirrorURL *url.URL, status int, cancel context.CancelFunc) {
+	defer cancel()
+
+	ev := &RequestEvent{
+		Host:         p.Host,
+		URL:          mirrorURL.String(),
+		Path:         mirrorURL.Path,
+		GoDocStatus:  status,
+		RedirectHost: "https://" + p.Host,
+	}
+
+	start := time.Now()
+	resp, err := p.Client.Do(req)
+	ev.Latency = time.Since(start)
+	if err == nil {
+		ev.Status = resp.StatusCode
+		resp.Body.Close()
+	}
+
+	p.Sink.Send(ev)
+}
+
+// MirrorURL returns the pkg.go.dev URL on host that mirrors a godoc.org
+// request for u.
+func MirrorURL(u *url.URL, host string) *url.URL {
+	path, query := pkgGoDevPath(u)
+	return &url.URL{
+		Scheme:   "https",
+		Host:     host,
+		Path:     path,
+		RawQuery: query.Encode(),
+	}
+}
+
+// pkgGoDevPath returns the pkg.go.dev path and query that correspond to a
+// godoc.org request for u, following the same rules as gddo-server's
+// pkgGoDevURL: "/-/about" becomes "/about", "?imports" becomes
+// "?tab=imports", and the root/search path is preserved.
+func pkgGoDevPath(u *url.URL) (path string, query url.Values) {
+	q := url.Values{}
+	switch {
+	case u.Path == "/-/about":
+		return "/about", q
+	case u.Path == "/-/go":
+		q.Set("tab", "packages")
+		return "/std", q
+	case u.Path == "/":
+		if term := u.Query().Get("q"); term != "" {
+			q.Set("q", term)
+		}
+		return "/search", q
+	default:
+		switch oq := u.Query(); {
+		case isSet(oq, "imports"):
+			q.Set("tab", "imports")
+		case isSet(oq, "importers"):
+			q.Set("tab", "importedby")
+		default:
+			q.Set("tab", "doc")
+		}
+		return u.Path, q
+	}
+}
+
+func isSet(q url.Values, key string) bool {
+	_, ok := q[key]
+	return ok
+}
+
+// hostLimiter caps how often a host may be sent a mirrored request.
+type hostLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     map[string]time.Time
+}
+
+func newHostLimiter(qps float64) *hostLimiter {
+	if qps <= 0 {
+		return nil
+	}
+	return &hostLimiter{
+		interval: time.Duration(float64(time.Second) / qps),
+		last:     make(map[string]time.Time),
+	}
+}
+
+func (l *hostLimiter) allow(host string) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if last, ok := l.last[host]; ok && now.Sub(last) < l.interval {
+		return false
+	}
+	l.last[host] = now
+	return true
+}
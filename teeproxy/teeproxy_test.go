@@ -0,0 +1,77 @@
+// Copyright 2013 The Go Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd.
+
+package teeproxy
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestMirrorURL(t *testing.T) {
+	for _, tt := range []struct {
+		from, to string
+	}{
+		{
+			from: "https://godoc.org/-/about",
+			to:   "https://pkg.go.dev/about",
+		},
+		{
+			from: "https://godoc.org/-/go",
+			to:   "https://pkg.go.dev/std?tab=packages",
+		},
+		{
+			from: "https://godoc.org/?q=foo",
+			to:   "https://pkg.go.dev/search?q=foo",
+		},
+		{
+			from: "https://godoc.org/cloud.google.com/go/storage",
+			to:   "https://pkg.go.dev/cloud.google.com/go/storage?tab=doc",
+		},
+		{
+			from: "https://godoc.org/cloud.google.com/go/storage?imports",
+			to:   "https://pkg.go.dev/cloud.google.com/go/storage?tab=imports",
+		},
+		{
+			from: "https://godoc.org/cloud.google.com/go/storage?importers",
+			to:   "https://pkg.go.dev/cloud.google.com/go/storage?tab=importedby",
+		},
+	} {
+		u, err := url.Parse(tt.from)
+		if err != nil {
+			t.Errorf("url.Parse(%q): %v", tt.from, err)
+			continue
+		}
+		got := MirrorURL(u, "pkg.go.dev")
+		if got.String() != tt.to {
+			t.Errorf("MirrorURL(%q) = %q; want %q", tt.from, got, tt.to)
+		}
+	}
+}
+
+type fakeSink struct {
+	events []interface{}
+}
+
+func (s *fakeSink) Send(e interface{}) {
+	s.events = append(s.events, e)
+}
+
+func TestProxyMirrorSkipsNonGET(t *testing.T) {
+	sink := &fakeSink{}
+	p := &Proxy{Host: "pkg.go.dev", Sink: sink, Sample: 1}
+
+	req, err := http.NewRequest(http.MethodPost, "https://godoc.org/net/http", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Mirror(req, 200)
+
+	if len(sink.events) != 0 {
+		t.Errorf("Mirror sent %d events for a POST request; want 0", len(sink.events))
+	}
+}